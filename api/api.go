@@ -0,0 +1,27 @@
+package api
+
+import (
+	"github.com/raintank/tsdb-gw/auth"
+)
+
+// authPlugin is satisfied by every auth backend the gateway knows how to
+// use (gcom, oidc, ...). It's intentionally the same shape as
+// auth.AuthPlugin so any of those can be plugged in directly.
+type authPlugin interface {
+	Auth(username, req string) (*auth.User, error)
+}
+
+// Api holds the dependencies shared by the gateway's HTTP middleware.
+type Api struct {
+	authPlugin authPlugin
+	TLS        *TLSCfg
+}
+
+// New returns an Api that authenticates requests using authPlugin. tls may
+// be nil, in which case client-certificate authentication is disabled.
+func New(authPlugin authPlugin, tls *TLSCfg) *Api {
+	return &Api{
+		authPlugin: authPlugin,
+		TLS:        tls,
+	}
+}
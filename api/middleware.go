@@ -1,10 +1,13 @@
 package api
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net/http"
 	"path"
+	"reflect"
 	"strconv"
 	"strings"
 	"sync"
@@ -28,7 +31,9 @@ var (
 		Name:      "request_duration_seconds",
 		Help:      "Time (in seconds) spent serving HTTP requests.",
 		Buckets:   prometheus.ExponentialBuckets(.05, 2, 10),
-	}, []string{"method", "route", "status_code"})
+	}, []string{"method", "route", "status_code", "auth_type"})
+
+	baseType = reflect.TypeOf((*models.Base)(nil)).Elem()
 )
 
 func init() {
@@ -48,57 +53,82 @@ func (rw *TracingResponseWriter) Write(b []byte) (int, error) {
 	return rw.ResponseWriter.Write(b)
 }
 
-func GetContextHandler() macaron.Handler {
+// GetAPIContextHandler maps a models.APIContext, used by /api/* data-ingest
+// routes, as both its concrete type and as models.Base.
+func GetAPIContextHandler() macaron.Handler {
 	return func(c *macaron.Context) {
-		ctx := &models.Context{
-			Context: c,
-			User:    &auth.User{},
+		ctx := &models.APIContext{
+			BaseContext: models.BaseContext{Context: c, User: &auth.User{}},
+			RequestID:   newRequestID(),
 		}
 		c.Map(ctx)
+		c.MapTo(ctx, (*models.Base)(nil))
+	}
+}
+
+// GetWebContextHandler maps a models.WebContext, used by admin/ops routes
+// that may render HTML or redirect, as both its concrete type and as
+// models.Base.
+func GetWebContextHandler() macaron.Handler {
+	return func(c *macaron.Context) {
+		ctx := &models.WebContext{
+			BaseContext: models.BaseContext{Context: c, User: &auth.User{}},
+		}
+		c.Map(ctx)
+		c.MapTo(ctx, (*models.Base)(nil))
 	}
 }
 
 func RequireAdmin() macaron.Handler {
-	return func(ctx *models.Context) {
-		if !ctx.IsAdmin {
-			ctx.JSON(403, "Permision denied")
+	return func(ctx models.Base) {
+		if !ctx.IsAdmin() {
+			ctx.Error(403, "Permision denied")
 		}
 	}
 }
 
 func (a *Api) Auth() macaron.Handler {
-	return func(ctx *models.Context) {
-		username, key, ok := ctx.Req.BasicAuth()
+	return func(ctx models.Base) {
+		if user, ok := a.authFromClientCert(ctx); ok {
+			ctx.SetUser(user)
+			ctx.SetAuthType(string(AuthTypeClientCert))
+			return
+		}
+
+		req := ctx.Mac().Req
+		username, key, ok := req.BasicAuth()
+		authType := AuthTypeBasic
 		if !ok {
 			// no basicAuth, but we also need to check for a Bearer Token
-			header := ctx.Req.Header.Get("Authorization")
+			header := req.Header.Get("Authorization")
 			parts := strings.SplitN(header, " ", 2)
 			if len(parts) == 2 && parts[0] == "Bearer" {
 				key = parts[1]
 				username = "api_key"
+				authType = AuthTypeBearer
 			}
 		}
 
 		if key == "" {
 			log.Debugf("no key specified")
-			ctx.JSON(401, "Unauthorized")
+			ctx.Error(401, "Unauthorized")
 			return
 		}
 
 		user, err := a.authPlugin.Auth(username, key)
 		if err != nil {
 			if err == auth.ErrInvalidCredentials || err == auth.ErrInvalidOrgId || err == auth.ErrInvalidInstanceID {
-				ctx.JSON(401, err.Error())
+				ctx.Error(401, err.Error())
 				return
 			}
 			log.Errorf("failed to perform authentication: %q", err.Error())
-			ctx.JSON(500, err.Error())
+			ctx.Error(500, err.Error())
 			return
 		}
 
 		// allow admin users to impersonate other orgs.
 		if user.IsAdmin {
-			header := ctx.Req.Header.Get("X-Tsdb-Org")
+			header := req.Header.Get("X-Tsdb-Org")
 			if header != "" {
 				orgId, err := strconv.ParseInt(header, 10, 64)
 				if err == nil && orgId != 0 {
@@ -106,16 +136,36 @@ func (a *Api) Auth() macaron.Handler {
 				}
 			}
 		}
-		ctx.User = user
+		ctx.SetUser(user)
+		ctx.SetAuthType(string(authType))
 	}
 }
 
+// authFromClientCert authenticates ctx using the client certificate
+// presented during the TLS handshake, if mutual TLS is configured and the
+// client presented one. It's checked ahead of basic/bearer auth so a
+// gateway running behind a service-mesh sidecar never needs an API key.
+func (a *Api) authFromClientCert(ctx models.Base) (*auth.User, bool) {
+	req := ctx.Mac().Req
+	if a.TLS == nil || req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
+		return nil, false
+	}
+	return a.TLS.userForCert(req.TLS.PeerCertificates[0])
+}
+
 func (a *Api) DDAuth() macaron.Handler {
-	return func(ctx *models.Context) {
+	return func(ctx models.Base) {
+		if user, ok := a.authFromClientCert(ctx); ok {
+			ctx.SetUser(user)
+			ctx.SetAuthType(string(AuthTypeClientCert))
+			return
+		}
+
 		var key string
 		var username string
 
-		header := ctx.Req.Header.Get("Dd-Api-Key")
+		req := ctx.Mac().Req
+		header := req.Header.Get("Dd-Api-Key")
 		parts := strings.SplitN(header, ":", 2)
 		if len(parts) == 1 {
 			key = parts[0]
@@ -130,24 +180,24 @@ func (a *Api) DDAuth() macaron.Handler {
 
 		if key == "" {
 			log.Debugf("no key specified")
-			ctx.JSON(401, "Unauthorized")
+			ctx.Error(401, "Unauthorized")
 			return
 		}
 
 		user, err := a.authPlugin.Auth(username, key)
 		if err != nil {
 			if err == auth.ErrInvalidCredentials || err == auth.ErrInvalidOrgId || err == auth.ErrInvalidInstanceID {
-				ctx.JSON(401, err.Error())
+				ctx.Error(401, err.Error())
 				return
 			}
 			log.Errorf("failed to perform authentication: %q", err.Error())
-			ctx.JSON(500, err.Error())
+			ctx.Error(500, err.Error())
 			return
 		}
 
 		// allow admin users to impersonate other orgs.
 		if user.IsAdmin {
-			header := ctx.Req.Header.Get("X-Tsdb-Org")
+			header := req.Header.Get("X-Tsdb-Org")
 			if header != "" {
 				orgId, err := strconv.ParseInt(header, 10, 64)
 				if err == nil && orgId != 0 {
@@ -155,7 +205,8 @@ func (a *Api) DDAuth() macaron.Handler {
 				}
 			}
 		}
-		ctx.User = user
+		ctx.SetUser(user)
+		ctx.SetAuthType(string(AuthTypeDDApiKey))
 	}
 }
 
@@ -166,7 +217,7 @@ type requestStats struct {
 	sizeMeters        map[string]*stats.Meter32
 }
 
-func (r *requestStats) PathStatusCount(ctx *models.Context, path string, status int) {
+func (r *requestStats) PathStatusCount(ctx models.Base, path string, status int) {
 	metricKey := fmt.Sprintf("api.request.%s.status.%d", path, status)
 	r.Lock()
 	p, ok := r.responseCounts[path]
@@ -181,10 +232,10 @@ func (r *requestStats) PathStatusCount(ctx *models.Context, path string, status
 	}
 	r.Unlock()
 	c.Inc()
-	usage.LogRequest(ctx.ID, metricKey)
+	usage.LogRequest(ctx.UserID(), metricKey)
 }
 
-func (r *requestStats) PathLatency(ctx *models.Context, path string, dur time.Duration) {
+func (r *requestStats) PathLatency(ctx models.Base, path string, dur time.Duration) {
 	r.Lock()
 	p, ok := r.latencyHistograms[path]
 	if !ok {
@@ -195,7 +246,7 @@ func (r *requestStats) PathLatency(ctx *models.Context, path string, dur time.Du
 	p.Value(dur)
 }
 
-func (r *requestStats) PathSize(ctx *models.Context, path string, size int) {
+func (r *requestStats) PathSize(ctx models.Base, path string, size int) {
 	r.Lock()
 	p, ok := r.sizeMeters[path]
 	if !ok {
@@ -214,14 +265,15 @@ func RequestStats() macaron.Handler {
 		sizeMeters:        make(map[string]*stats.Meter32),
 	}
 
-	return func(ctx *models.Context) {
+	return func(ctx models.Base) {
 		start := time.Now()
-		rw := ctx.Resp.(macaron.ResponseWriter)
+		mac := ctx.Mac()
+		rw := mac.Resp.(macaron.ResponseWriter)
 		// call next handler. This will return after all handlers
 		// have completed and the request has been sent.
-		ctx.Next()
+		mac.Next()
 		status := rw.Status()
-		path := pathSlug(ctx.Req.URL.Path)
+		path := pathSlug(mac.Req.URL.Path)
 		stats.PathStatusCount(ctx, path, status)
 		stats.PathLatency(ctx, path, time.Since(start))
 		// only record the request size if the request succeeded.
@@ -232,18 +284,19 @@ func RequestStats() macaron.Handler {
 }
 
 func (a *Api) PromStats(handler string) macaron.Handler {
-	return func(ctx *models.Context) {
+	return func(ctx models.Base) {
 		start := time.Now()
-		rw := ctx.Resp.(macaron.ResponseWriter)
+		mac := ctx.Mac()
+		rw := mac.Resp.(macaron.ResponseWriter)
 		// call next handler. This will return after all handlers
 		// have completed and the request has been sent.
-		ctx.Next()
+		mac.Next()
 
 		status := strconv.Itoa(rw.Status())
 		took := time.Since(start)
-		method := ctx.Req.Method
+		method := mac.Req.Method
 
-		requestDuration.WithLabelValues(method, handler, status).Observe(took.Seconds())
+		requestDuration.WithLabelValues(method, handler, status, ctx.GetAuthType()).Observe(took.Seconds())
 	}
 }
 
@@ -280,6 +333,11 @@ func Tracer(componentName string) macaron.Handler {
 		macCtx.Next()
 		status := rw.Status()
 		ext.HTTPStatusCode.Set(span, uint16(status))
+		if v := macCtx.Get(baseType); v.IsValid() {
+			if base, ok := v.Interface().(models.Base); ok {
+				span.SetTag("auth.type", base.GetAuthType())
+			}
+		}
 		if status >= 200 && status < 300 {
 			span.SetTag("http.size", rw.Size())
 		}
@@ -292,3 +350,11 @@ func Tracer(componentName string) macaron.Handler {
 		span.Finish()
 	}
 }
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
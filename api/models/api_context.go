@@ -0,0 +1,25 @@
+package models
+
+// ErrorEnvelope is the structured error body every /api/* endpoint
+// returns, replacing the old ad-hoc ctx.JSON(401, "some string") calls.
+type ErrorEnvelope struct {
+	Code      int    `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"requestID"`
+}
+
+// APIContext is used for data-ingest and other /api/* routes. It always
+// writes JSON, including for errors, so clients get a consistent
+// code/message/requestID envelope regardless of which handler failed.
+type APIContext struct {
+	BaseContext
+	RequestID string
+}
+
+func (c *APIContext) Error(status int, message string) {
+	c.JSON(status, ErrorEnvelope{
+		Code:      status,
+		Message:   message,
+		RequestID: c.RequestID,
+	})
+}
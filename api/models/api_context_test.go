@@ -0,0 +1,30 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// APIContext.Error renders ErrorEnvelope through macaron's Render, which
+// needs a live render middleware to construct; exercising that wiring is
+// left to integration testing. What's tested here, and what actually tends
+// to drift, is the envelope's own JSON shape.
+func TestErrorEnvelopeJSON(t *testing.T) {
+	Convey("When an ErrorEnvelope is marshaled to JSON", t, func() {
+		envelope := ErrorEnvelope{
+			Code:      401,
+			Message:   "Unauthorized",
+			RequestID: "abc123",
+		}
+		b, err := json.Marshal(envelope)
+		So(err, ShouldBeNil)
+
+		var decoded map[string]interface{}
+		So(json.Unmarshal(b, &decoded), ShouldBeNil)
+		So(decoded["code"], ShouldEqual, 401)
+		So(decoded["message"], ShouldEqual, "Unauthorized")
+		So(decoded["requestID"], ShouldEqual, "abc123")
+	})
+}
@@ -0,0 +1,73 @@
+package models
+
+import (
+	opentracing "github.com/opentracing/opentracing-go"
+	"gopkg.in/macaron.v1"
+
+	"github.com/raintank/tsdb-gw/auth"
+)
+
+// Base is the context surface shared by every route the gateway serves.
+// Middleware that doesn't care whether it's running on an API or a web/
+// admin route (Auth, DDAuth, RequireAdmin, RequestStats, PromStats, ...)
+// is written against Base; Tracer takes the raw *macaron.Context and only
+// looks Base up after the request has been served, to tag its span with the
+// auth type. APIContext and WebContext are Base's two concrete
+// implementations, and which one a given route gets is decided by that
+// route group's middleware chain, not by Base itself.
+type Base interface {
+	// Mac returns the underlying macaron.Context, for access to the raw
+	// request/response/session.
+	Mac() *macaron.Context
+
+	GetUser() *auth.User
+	SetUser(u *auth.User)
+	IsAdmin() bool
+	UserID() int
+
+	GetAuthType() string
+	SetAuthType(t string)
+
+	Span() opentracing.Span
+	SetSpan(span opentracing.Span)
+
+	// Error writes an error response in whatever shape this context type
+	// uses: a structured JSON envelope for APIContext, a plain-text body for
+	// WebContext.
+	Error(status int, message string)
+}
+
+// BaseContext holds the state and accessors common to every concrete
+// context type. It is embedded by APIContext and WebContext, never used
+// directly.
+type BaseContext struct {
+	*macaron.Context
+	User     *auth.User
+	AuthType string
+	span     opentracing.Span
+}
+
+func (c *BaseContext) Mac() *macaron.Context { return c.Context }
+
+func (c *BaseContext) GetUser() *auth.User  { return c.User }
+func (c *BaseContext) SetUser(u *auth.User) { c.User = u }
+func (c *BaseContext) IsAdmin() bool        { return c.User != nil && c.User.IsAdmin }
+func (c *BaseContext) UserID() int {
+	if c.User == nil {
+		return 0
+	}
+	return c.User.ID
+}
+
+// GetAuthType returns the mechanism that authenticated this request, or
+// "none" if authentication hasn't run yet.
+func (c *BaseContext) GetAuthType() string {
+	if c.AuthType == "" {
+		return "none"
+	}
+	return c.AuthType
+}
+func (c *BaseContext) SetAuthType(t string) { c.AuthType = t }
+
+func (c *BaseContext) Span() opentracing.Span        { return c.span }
+func (c *BaseContext) SetSpan(span opentracing.Span) { c.span = span }
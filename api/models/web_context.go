@@ -0,0 +1,18 @@
+package models
+
+// WebContext is used for admin/ops routes, which may render HTML or issue
+// redirects rather than the strict JSON envelope APIContext uses.
+type WebContext struct {
+	BaseContext
+}
+
+func (c *WebContext) Error(status int, message string) {
+	c.PlainText(status, []byte(message))
+}
+
+// RedirectTo issues a 302 redirect to url. It is a thin wrapper around the
+// embedded macaron.Context's Redirect, defined on WebContext only; Base has
+// no redirect method.
+func (c *WebContext) RedirectTo(url string) {
+	c.Redirect(url)
+}
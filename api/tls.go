@@ -0,0 +1,174 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/raintank/tsdb-gw/auth"
+)
+
+var (
+	tlsCertFile     string
+	tlsKeyFile      string
+	tlsClientCAFile string
+	tlsRulesFile    string
+)
+
+func init() {
+	flag.StringVar(&tlsCertFile, "tls-cert-file", "", "certificate file to serve TLS with. If empty, TLS is disabled")
+	flag.StringVar(&tlsKeyFile, "tls-key-file", "", "key file to serve TLS with")
+	flag.StringVar(&tlsClientCAFile, "tls-client-ca-file", "", "CA bundle used to verify client certificates. If empty, mutual TLS is disabled")
+	flag.StringVar(&tlsRulesFile, "tls-rules-file", "", "JSON file of CertMappingRule entries used to map a verified client certificate onto an auth.User")
+}
+
+// AuthType identifies the mechanism that authenticated a request. It's
+// recorded as a Prometheus label and an OpenTracing span tag so operators
+// can tell basic-auth, bearer-token and client-certificate traffic apart.
+type AuthType string
+
+const (
+	AuthTypeNone       AuthType = "none"
+	AuthTypeBasic      AuthType = "basic"
+	AuthTypeBearer     AuthType = "bearer"
+	AuthTypeDDApiKey   AuthType = "dd_api_key"
+	AuthTypeClientCert AuthType = "client_cert"
+)
+
+// CertMappingRule maps a verified client certificate onto an auth.User when
+// Match equals the certificate's CommonName or one of its SAN URIs or email
+// addresses. No identity takes precedence over another; see userForCert.
+type CertMappingRule struct {
+	Match   string
+	OrgId   int64
+	Role    string
+	IsAdmin bool
+}
+
+// TLSCfg consolidates the gateway's server-side TLS options: the
+// certificate/key the gateway serves, and, for mutual TLS, the CA bundle
+// used to validate client certificates plus the rules used to map a
+// verified certificate onto an auth.User.
+type TLSCfg struct {
+	CertFile string
+	KeyFile  string
+
+	// ClientCAFile, if set, enables mutual TLS: the gateway requests and
+	// verifies a client certificate against this CA bundle. Rules then
+	// decides which auth.User that certificate maps to.
+	ClientCAFile string
+	Rules        []CertMappingRule
+}
+
+// NewTLSCfg builds a TLSCfg from the -tls-* flags. It returns (nil, nil) if
+// -tls-cert-file isn't set, so callers can treat an unset flag as "don't
+// serve TLS" rather than an error.
+func NewTLSCfg() (*TLSCfg, error) {
+	if tlsCertFile == "" {
+		return nil, nil
+	}
+
+	t := &TLSCfg{
+		CertFile:     tlsCertFile,
+		KeyFile:      tlsKeyFile,
+		ClientCAFile: tlsClientCAFile,
+	}
+
+	if tlsRulesFile != "" {
+		rules, err := loadCertMappingRules(tlsRulesFile)
+		if err != nil {
+			return nil, err
+		}
+		t.Rules = rules
+	}
+
+	return t, nil
+}
+
+// loadCertMappingRules reads a JSON array of CertMappingRule from path.
+func loadCertMappingRules(path string) ([]CertMappingRule, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tls rules file %q: %s", path, err)
+	}
+	var rules []CertMappingRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse tls rules file %q: %s", path, err)
+	}
+	return rules, nil
+}
+
+// Config builds the tls.Config the gateway's HTTP server should listen
+// with: the server's own certificate, plus - if ClientCAFile is set - the
+// client CA pool and ClientAuth mode that make the server request client
+// certificates and verify any it is given (see ClientAuthType; presenting
+// one is not required).
+func (t *TLSCfg) Config() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate/key: %s", err)
+	}
+
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   t.ClientAuthType(),
+	}
+
+	if t.ClientCAFile != "" {
+		pool, err := t.ClientCAPool()
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs = pool
+	}
+
+	return cfg, nil
+}
+
+// ClientCAPool loads and parses ClientCAFile.
+func (t *TLSCfg) ClientCAPool() (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(t.ClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA bundle %q: %s", t.ClientCAFile, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in client CA bundle %q", t.ClientCAFile)
+	}
+	return pool, nil
+}
+
+// ClientAuthType returns the tls.Config ClientAuth mode implied by this
+// TLSCfg: mutual TLS is requested but not required, so requests without a
+// client certificate still fall through to basic/bearer auth.
+func (t *TLSCfg) ClientAuthType() tls.ClientAuthType {
+	if t == nil || t.ClientCAFile == "" {
+		return tls.NoClientCert
+	}
+	return tls.VerifyClientCertIfGiven
+}
+
+// userForCert maps a verified client certificate to an auth.User using
+// Rules, in order. It returns false if no rule matches.
+func (t *TLSCfg) userForCert(cert *x509.Certificate) (*auth.User, bool) {
+	candidates := make([]string, 0, 1+len(cert.URIs)+len(cert.EmailAddresses))
+	if cert.Subject.CommonName != "" {
+		candidates = append(candidates, cert.Subject.CommonName)
+	}
+	for _, u := range cert.URIs {
+		candidates = append(candidates, u.String())
+	}
+	candidates = append(candidates, cert.EmailAddresses...)
+
+	for _, rule := range t.Rules {
+		for _, c := range candidates {
+			if c == rule.Match {
+				return &auth.User{OrgId: rule.OrgId, Role: rule.Role, IsAdmin: rule.IsAdmin}, true
+			}
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUserForCert(t *testing.T) {
+	cfg := &TLSCfg{
+		Rules: []CertMappingRule{
+			{Match: "ingest.example.com", OrgId: 1, Role: "Editor"},
+			{Match: "spn:ingest@example.com", OrgId: 2, Role: "Admin", IsAdmin: true},
+			{Match: "svc@example.com", OrgId: 3, Role: "Viewer"},
+		},
+	}
+
+	Convey("When a certificate's CommonName matches a rule", t, func() {
+		cert := &x509.Certificate{Subject: pkix.Name{CommonName: "ingest.example.com"}}
+		user, ok := cfg.userForCert(cert)
+		So(ok, ShouldBeTrue)
+		So(user.OrgId, ShouldEqual, 1)
+		So(user.Role, ShouldEqual, "Editor")
+		So(user.IsAdmin, ShouldBeFalse)
+	})
+
+	Convey("When a certificate has no matching CommonName but a matching SAN URI", t, func() {
+		uri, err := url.Parse("spn:ingest@example.com")
+		So(err, ShouldBeNil)
+		cert := &x509.Certificate{
+			Subject: pkix.Name{CommonName: "unrelated"},
+			URIs:    []*url.URL{uri},
+		}
+		user, ok := cfg.userForCert(cert)
+		So(ok, ShouldBeTrue)
+		So(user.OrgId, ShouldEqual, 2)
+		So(user.IsAdmin, ShouldBeTrue)
+	})
+
+	Convey("When a certificate has no matching CommonName or SAN URI but a matching SAN email", t, func() {
+		cert := &x509.Certificate{
+			Subject:        pkix.Name{CommonName: "unrelated"},
+			EmailAddresses: []string{"svc@example.com"},
+		}
+		user, ok := cfg.userForCert(cert)
+		So(ok, ShouldBeTrue)
+		So(user.OrgId, ShouldEqual, 3)
+		So(user.Role, ShouldEqual, "Viewer")
+	})
+
+	Convey("When nothing on the certificate matches any rule", t, func() {
+		cert := &x509.Certificate{Subject: pkix.Name{CommonName: "nobody.example.com"}}
+		user, ok := cfg.userForCert(cert)
+		So(ok, ShouldBeFalse)
+		So(user, ShouldBeNil)
+	})
+}
+
+func TestLoadCertMappingRules(t *testing.T) {
+	Convey("When loading a rules file with valid JSON", t, func() {
+		dir, err := ioutil.TempDir("", "tls-rules")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		path := filepath.Join(dir, "rules.json")
+		So(ioutil.WriteFile(path, []byte(`[{"Match":"ingest.example.com","OrgId":1,"Role":"Editor"}]`), 0644), ShouldBeNil)
+
+		rules, err := loadCertMappingRules(path)
+		So(err, ShouldBeNil)
+		So(rules, ShouldHaveLength, 1)
+		So(rules[0].Match, ShouldEqual, "ingest.example.com")
+		So(rules[0].OrgId, ShouldEqual, 1)
+	})
+
+	Convey("When the rules file doesn't exist", t, func() {
+		_, err := loadCertMappingRules("/no/such/file.json")
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,28 @@
+package auth
+
+import "errors"
+
+var (
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrInvalidOrgId       = errors.New("invalid org id")
+	ErrInvalidInstanceID  = errors.New("invalid instance id")
+)
+
+// User represents an authenticated caller, resolved by an AuthPlugin from
+// whatever credential the request carried (api key, bearer token, client
+// certificate, etc).
+type User struct {
+	ID      int
+	OrgId   int64
+	Role    string
+	IsAdmin bool
+}
+
+// AuthPlugin resolves a username/credential pair, as extracted from an
+// incoming request, to a User. Implementations are free to reject the
+// credential outright (ErrInvalidCredentials), reject it based on org/
+// instance policy (ErrInvalidOrgId, ErrInvalidInstanceID), or hand it to
+// another AuthPlugin they wrap.
+type AuthPlugin interface {
+	Auth(username, req string) (*User, error)
+}
@@ -3,6 +3,8 @@ package gcom
 import (
 	"fmt"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -222,10 +224,11 @@ func TestCheckInstance(t *testing.T) {
 
 	testInstance := Instance{
 		ID:    10,
-		OrgID: 3,
+		OrgID: testUser.OrgId,
 	}
 
 	Convey("when checking valid instanceID", t, func() {
+		instanceCache.Clear()
 		responder, err := httpmock.NewJsonResponder(200, &testInstance)
 		So(err, ShouldBeNil)
 		mockTransport.RegisterResponder("GET", "https://grafana.com/api/hosted-metrics/10", responder)
@@ -240,7 +243,7 @@ func TestCheckInstance(t *testing.T) {
 		So(err, ShouldBeNil)
 		mockTransport.RegisterResponder("GET", "https://grafana.com/api/hosted-metrics/10", responder)
 
-		instanceCache.Set("awoodsTest-10", true, time.Second)
+		instanceCache.Set("awoodsTest-10", instanceCacheEntry{Valid: true, OrgID: testUser.OrgId}, time.Second)
 		err = testUser.CheckInstance("10")
 		So(err, ShouldEqual, nil)
 		mockTransport.Reset()
@@ -249,12 +252,19 @@ func TestCheckInstance(t *testing.T) {
 		mockTransport.RegisterResponder("GET", "https://grafana.com/api/hosted-metrics/10", func(req *http.Request) (*http.Response, error) {
 			return nil, fmt.Errorf("failed")
 		})
-		instanceCache.Set("awoodsTest-10", true, 0)
+		instanceCache.Set("awoodsTest-10", instanceCacheEntry{Valid: true, OrgID: testUser.OrgId}, 0)
 		err := testUser.CheckInstance("10")
 		So(err, ShouldEqual, nil)
 		mockTransport.Reset()
 	})
+	Convey("when checking an instance belonging to a different org, even on cache hit", t, func() {
+		instanceCache.Set("awoodsTest-10", instanceCacheEntry{Valid: true, OrgID: testUser.OrgId + 1}, time.Second)
+		err := testUser.CheckInstance("10")
+		So(err, ShouldEqual, ErrInvalidOrgId)
+		instanceCache.Clear()
+	})
 	Convey("when checking invalid instanceID", t, func() {
+		instanceCache.Clear()
 		responder, err := httpmock.NewJsonResponder(404, "not found")
 		So(err, ShouldBeNil)
 		mockTransport.RegisterResponder("GET", "https://grafana.com/api/hosted-metrics/20", responder)
@@ -263,4 +273,56 @@ func TestCheckInstance(t *testing.T) {
 		So(err, ShouldEqual, ErrInvalidInstanceID)
 		mockTransport.Reset()
 	})
+
+	Convey("when checking an invalid instanceID repeatedly within the negative-cache TTL", t, func() {
+		instanceCache.Clear()
+		var calls int32
+		mockTransport.RegisterResponder("GET", "https://grafana.com/api/hosted-metrics/30", func(req *http.Request) (*http.Response, error) {
+			atomic.AddInt32(&calls, 1)
+			resp, _ := httpmock.NewJsonResponder(404, "not found")(req)
+			return resp, nil
+		})
+
+		err := testUser.CheckInstance("30")
+		So(err, ShouldEqual, ErrInvalidInstanceID)
+		err = testUser.CheckInstance("30")
+		So(err, ShouldEqual, ErrInvalidInstanceID)
+		So(atomic.LoadInt32(&calls), ShouldEqual, 1)
+
+		// force the negative cache entry to expire, then check it's
+		// actually re-verified rather than trusted forever.
+		instanceCache.Set("awoodsTest-30", instanceCacheEntry{Valid: false}, 0)
+		err = testUser.CheckInstance("30")
+		So(err, ShouldEqual, ErrInvalidInstanceID)
+		So(atomic.LoadInt32(&calls), ShouldEqual, 2)
+		mockTransport.Reset()
+	})
+
+	Convey("when N goroutines check the same instanceID concurrently", t, func() {
+		instanceCache.Clear()
+		var calls int32
+		mockTransport.RegisterResponder("GET", "https://grafana.com/api/hosted-metrics/10", func(req *http.Request) (*http.Response, error) {
+			atomic.AddInt32(&calls, 1)
+			time.Sleep(10 * time.Millisecond)
+			resp, _ := httpmock.NewJsonResponder(200, &testInstance)(req)
+			return resp, nil
+		})
+
+		var wg sync.WaitGroup
+		errs := make([]error, 20)
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs[i] = testUser.CheckInstance("10")
+			}(i)
+		}
+		wg.Wait()
+
+		for _, err := range errs {
+			So(err, ShouldBeNil)
+		}
+		So(atomic.LoadInt32(&calls), ShouldEqual, 1)
+		mockTransport.Reset()
+	})
 }
@@ -0,0 +1,331 @@
+// Package gcom authenticates against grafana.com: api keys are checked
+// (and cached) via the grafana.com api-keys endpoint, and hosted-metrics
+// instance IDs are validated against the instance the key's org actually
+// owns. Plugin adapts this into an auth.AuthPlugin for use by api.Api and
+// as oidc.Plugin's fallback.
+package gcom
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/raintank/tsdb-gw/auth"
+)
+
+var (
+	ErrInvalidOrgId      = auth.ErrInvalidOrgId
+	ErrInvalidInstanceID = auth.ErrInvalidInstanceID
+)
+
+// Role mirrors the role names grafana.com assigns to an api key's org
+// membership.
+type Role string
+
+const (
+	ROLE_VIEWER Role = "Viewer"
+	ROLE_EDITOR Role = "Editor"
+	ROLE_ADMIN  Role = "Admin"
+)
+
+const (
+	apiKeyCheckURL  = "https://grafana.com/api/api-keys/check"
+	instanceURLBase = "https://grafana.com/api/hosted-metrics/"
+
+	userCacheTTL = time.Minute
+
+	// positiveInstanceTTL/negativeInstanceTTL are deliberately far apart: a
+	// 404 is cached only briefly so a transient grafana.com hiccup doesn't
+	// lock a legitimate instance ID out for as long as a confirmed-valid
+	// one is trusted for.
+	positiveInstanceTTL = 5 * time.Minute
+	negativeInstanceTTL = 5 * time.Second
+)
+
+var (
+	adminKey      string
+	validOrgIds   int64SliceFlag
+	client        = &http.Client{Timeout: 10 * time.Second}
+	cache         = newTTLCache()
+	instanceCache = newTTLCache()
+	checkGroup    singleflight.Group
+)
+
+func init() {
+	flag.StringVar(&adminKey, "gcom-admin-key", "key", "admin api key, bypasses grafana.com and is always granted ROLE_ADMIN")
+	flag.Var(&validOrgIds, "gcom-valid-org-ids", "comma separated list of org ids allowed to authenticate. If empty, all orgs are allowed")
+}
+
+// SignedInUser is the api key owner resolved by Auth, decoded directly
+// from grafana.com's api-keys/check response.
+type SignedInUser struct {
+	Id        int
+	OrgId     int64
+	OrgName   string
+	OrgSlug   string
+	Name      string
+	Role      Role
+	IsAdmin   bool
+	CreatedAt time.Time
+
+	key string
+}
+
+// Auth validates key against grafana.com, caching successful results for
+// userCacheTTL; failures are not cached. If grafana.com is unreachable or
+// erroring, a previously cached result for key is served (and re-cached for
+// userCacheTTL) however long ago it expired, so an outage there doesn't take
+// down authentication gateway-wide.
+func Auth(username, key string) (*SignedInUser, error) {
+	if username == "key" && key == adminKey {
+		return &SignedInUser{
+			OrgId:   1,
+			OrgName: "Admin",
+			Role:    ROLE_ADMIN,
+			IsAdmin: true,
+			key:     key,
+		}, nil
+	}
+
+	cached, fresh := cache.Get(key)
+	if fresh {
+		return cached.(*SignedInUser), nil
+	}
+
+	user, err := fetchUser(key)
+	if err != nil {
+		if cached != nil {
+			log.Warnf("gcom: failed to authenticate key, serving stale cached result: %s", err)
+			staleUser := cached.(*SignedInUser)
+			cache.Set(key, staleUser, userCacheTTL)
+			return staleUser, nil
+		}
+		return nil, err
+	}
+
+	if len(validOrgIds) > 0 && !validOrgIds.Contains(user.OrgId) {
+		return nil, ErrInvalidOrgId
+	}
+
+	user.key = key
+	cache.Set(key, user, userCacheTTL)
+	return user, nil
+}
+
+// Plugin adapts Auth into an auth.AuthPlugin, for use as api.Api's
+// authPlugin or as another plugin's fallback (e.g. oidc.Plugin.Fallback).
+type Plugin struct{}
+
+func (Plugin) Auth(username, key string) (*auth.User, error) {
+	user, err := Auth(username, key)
+	if err != nil {
+		return nil, err
+	}
+	return &auth.User{
+		ID:      user.Id,
+		OrgId:   user.OrgId,
+		Role:    string(user.Role),
+		IsAdmin: user.IsAdmin,
+	}, nil
+}
+
+func fetchUser(key string) (*SignedInUser, error) {
+	body, err := json.Marshal(map[string]string{"key": key})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", apiKeyCheckURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("gcom: unexpected status %d checking api key", resp.StatusCode)
+	}
+
+	user := &SignedInUser{}
+	if err := json.NewDecoder(resp.Body).Decode(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Instance is a hosted-metrics instance as returned by grafana.com.
+type Instance struct {
+	ID    int64 `json:"id"`
+	OrgID int64 `json:"orgId"`
+}
+
+// instanceCacheEntry is what instanceCache stores: whether instanceID
+// exists at all, and - if it does - which org it belongs to, so a cache
+// hit can still be rejected if it belongs to a different org than u.
+type instanceCacheEntry struct {
+	Valid bool
+	OrgID int64
+}
+
+// CheckInstance verifies that instanceID is a hosted-metrics instance
+// belonging to u's org. Concurrent calls for the same org/instance pair
+// are coalesced into a single grafana.com request via singleflight.
+func (u *SignedInUser) CheckInstance(instanceID string) error {
+	key := u.OrgSlug + "-" + instanceID
+
+	cached, fresh := instanceCache.Get(key)
+	if fresh {
+		return validateInstance(u, cached.(instanceCacheEntry))
+	}
+
+	v, err, _ := checkGroup.Do(key, func() (interface{}, error) {
+		return fetchInstance(instanceID)
+	})
+	if err != nil {
+		// A confirmed 404 is a real, fresh answer - not a reason to fall
+		// back to a stale cached entry - even if that entry was Valid, so a
+		// revoked instance actually becomes invalid rather than being
+		// re-affirmed from cache forever.
+		if err == ErrInvalidInstanceID {
+			instanceCache.Set(key, instanceCacheEntry{Valid: false}, negativeInstanceTTL)
+			return err
+		}
+		if cached != nil {
+			log.Warnf("gcom: failed to check instance %s, serving stale cached result: %s", instanceID, err)
+			entry := cached.(instanceCacheEntry)
+			ttl := positiveInstanceTTL
+			if !entry.Valid {
+				ttl = negativeInstanceTTL
+			}
+			instanceCache.Set(key, entry, ttl)
+			return validateInstance(u, entry)
+		}
+		return err
+	}
+
+	entry := v.(instanceCacheEntry)
+	instanceCache.Set(key, entry, positiveInstanceTTL)
+	return validateInstance(u, entry)
+}
+
+func validateInstance(u *SignedInUser, entry instanceCacheEntry) error {
+	if !entry.Valid {
+		return ErrInvalidInstanceID
+	}
+	if entry.OrgID != u.OrgId {
+		return ErrInvalidOrgId
+	}
+	return nil
+}
+
+func fetchInstance(instanceID string) (instanceCacheEntry, error) {
+	resp, err := client.Get(instanceURLBase + instanceID)
+	if err != nil {
+		return instanceCacheEntry{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return instanceCacheEntry{}, ErrInvalidInstanceID
+	}
+	if resp.StatusCode != http.StatusOK {
+		return instanceCacheEntry{}, fmt.Errorf("gcom: unexpected status %d checking instance", resp.StatusCode)
+	}
+
+	var inst Instance
+	if err := json.NewDecoder(resp.Body).Decode(&inst); err != nil {
+		return instanceCacheEntry{}, err
+	}
+	return instanceCacheEntry{Valid: true, OrgID: inst.OrgID}, nil
+}
+
+// int64SliceFlag is a flag.Value accepting a comma separated list of
+// int64s, e.g. -gcom-valid-org-ids=1,2,3.
+type int64SliceFlag []int64
+
+func (f *int64SliceFlag) String() string {
+	strs := make([]string, len(*f))
+	for i, v := range *f {
+		strs[i] = strconv.FormatInt(v, 10)
+	}
+	return strings.Join(strs, ",")
+}
+
+func (f *int64SliceFlag) Set(s string) error {
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		v, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			return err
+		}
+		*f = append(*f, v)
+	}
+	return nil
+}
+
+func (f int64SliceFlag) Contains(v int64) bool {
+	for _, x := range f {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheItem pairs a cached value with its expiry.
+type cacheItem struct {
+	value   interface{}
+	expires time.Time
+}
+
+// ttlCache is a small stale-while-revalidate cache: Get always returns the
+// cached value, if any, alongside whether it's still within its TTL, so
+// callers can choose to serve a stale value rather than treat a miss as
+// fatal.
+type ttlCache struct {
+	mu    sync.RWMutex
+	items map[string]cacheItem
+}
+
+func newTTLCache() *ttlCache {
+	return &ttlCache{items: make(map[string]cacheItem)}
+}
+
+func (c *ttlCache) Set(key string, value interface{}, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.items[key] = cacheItem{value: value, expires: time.Now().Add(ttl)}
+}
+
+func (c *ttlCache) Get(key string) (interface{}, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	item, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	return item.value, time.Now().Before(item.expires)
+}
+
+func (c *ttlCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.items = make(map[string]cacheItem)
+}
@@ -0,0 +1,375 @@
+// Package oidc implements an auth.AuthPlugin that authenticates requests
+// using JWT bearer tokens issued by an OpenID Connect provider. Tokens are
+// verified against the provider's published JWKS, which is discovered once
+// at startup and refreshed periodically in the background.
+package oidc
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/raintank/tsdb-gw/auth"
+)
+
+const (
+	discoveryPathSuffix = "/.well-known/openid-configuration"
+
+	// tokenSoftTTL is how long a successfully verified token is served from
+	// cache without re-verification. tokenHardTTL is how long after its
+	// verification a cached result keeps being served, as a fallback, if
+	// re-verification fails (e.g. the IdP is briefly unreachable).
+	tokenSoftTTL = time.Minute
+	tokenHardTTL = time.Hour
+)
+
+// ClaimsMapping configures which JWT claims populate which auth.User
+// fields. The zero value is not usable; use DefaultClaimsMapping as a
+// starting point.
+type ClaimsMapping struct {
+	OrgIdClaim      string
+	RoleClaim       string
+	InstanceIdClaim string
+	AdminClaim      string
+}
+
+// DefaultClaimsMapping returns the claim names used by most OIDC providers
+// that have been configured to issue tsdb-gw compatible tokens.
+func DefaultClaimsMapping() ClaimsMapping {
+	return ClaimsMapping{
+		OrgIdClaim:      "org_id",
+		RoleClaim:       "role",
+		InstanceIdClaim: "instance_id",
+		AdminClaim:      "admin",
+	}
+}
+
+// Plugin is an auth.AuthPlugin backed by a single OIDC issuer. If Fallback
+// is set, credentials that don't look like a JWT (e.g. a gcom api key) are
+// handed to it instead of being rejected, so OIDC can be rolled out
+// alongside the existing gcom auth.
+type Plugin struct {
+	Issuer   string
+	Audience string
+	Claims   ClaimsMapping
+	Fallback auth.AuthPlugin
+
+	keys  *jwksCache
+	cache *tokenCache
+}
+
+// New discovers the issuer's JWKS endpoint and returns a ready to use
+// Plugin. refresh controls how often the JWKS is re-fetched in the
+// background.
+func New(issuer, audience string, claims ClaimsMapping, fallback auth.AuthPlugin, refresh time.Duration) (*Plugin, error) {
+	keys, err := newJWKSCache(issuer, refresh)
+	if err != nil {
+		return nil, err
+	}
+	return &Plugin{
+		Issuer:   issuer,
+		Audience: audience,
+		Claims:   claims,
+		Fallback: fallback,
+		keys:     keys,
+		cache:    newTokenCache(),
+	}, nil
+}
+
+// Auth implements auth.AuthPlugin. req is the bearer token extracted from
+// the Authorization header.
+func (p *Plugin) Auth(username, req string) (*auth.User, error) {
+	if !looksLikeJWT(req) {
+		if p.Fallback != nil {
+			return p.Fallback.Auth(username, req)
+		}
+		return nil, auth.ErrInvalidCredentials
+	}
+
+	key := tokenCacheKey(req)
+	if user, fresh := p.cache.Get(key); fresh {
+		return user, nil
+	}
+
+	user, err := p.verify(req)
+	if err != nil {
+		// the token parsed as a JWT but failed verification (expired,
+		// bad signature, unknown kid, ...); a recently-cached result is
+		// still honoured so a brief JWKS outage doesn't lock everyone
+		// out, mirroring gcom's "expired cache and bad response"
+		// fallback in Auth.
+		if stale, ok := p.cache.GetStale(key); ok {
+			log.Warnf("oidc: failed to verify token, serving stale cached result: %s", err)
+			return stale, nil
+		}
+		return nil, err
+	}
+
+	p.cache.Set(key, user)
+	return user, nil
+}
+
+func (p *Plugin) verify(tokenString string) (*auth.User, error) {
+	token, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.keys.Get(kid)
+	})
+	if err != nil {
+		return nil, auth.ErrInvalidCredentials
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, auth.ErrInvalidCredentials
+	}
+	if !claims.VerifyIssuer(p.Issuer, true) {
+		return nil, auth.ErrInvalidCredentials
+	}
+	if p.Audience != "" && !claims.VerifyAudience(p.Audience, true) {
+		return nil, auth.ErrInvalidCredentials
+	}
+	now := time.Now().Unix()
+	if !claims.VerifyExpiresAt(now, true) {
+		return nil, auth.ErrInvalidCredentials
+	}
+	if !claims.VerifyNotBefore(now, false) {
+		return nil, auth.ErrInvalidCredentials
+	}
+
+	return p.mapUser(claims)
+}
+
+func (p *Plugin) mapUser(claims jwt.MapClaims) (*auth.User, error) {
+	orgId, err := claimToInt64(claims[p.Claims.OrgIdClaim])
+	if err != nil {
+		return nil, auth.ErrInvalidOrgId
+	}
+
+	user := &auth.User{
+		OrgId: orgId,
+	}
+	if role, ok := claims[p.Claims.RoleClaim].(string); ok {
+		user.Role = role
+	}
+	if admin, ok := claims[p.Claims.AdminClaim].(bool); ok {
+		user.IsAdmin = admin
+	}
+	if p.Claims.InstanceIdClaim != "" {
+		if _, ok := claims[p.Claims.InstanceIdClaim]; ok {
+			instanceId, err := claimToInt64(claims[p.Claims.InstanceIdClaim])
+			if err != nil {
+				return nil, auth.ErrInvalidInstanceID
+			}
+			user.ID = int(instanceId)
+		}
+	}
+
+	return user, nil
+}
+
+func claimToInt64(v interface{}) (int64, error) {
+	switch n := v.(type) {
+	case float64:
+		return int64(n), nil
+	case string:
+		return strconv.ParseInt(n, 10, 64)
+	default:
+		return 0, fmt.Errorf("claim is not numeric: %v", v)
+	}
+}
+
+// looksLikeJWT reports whether s has the three dot-separated segments of a
+// JWT. It does not validate the segments themselves; that's verify's job.
+func looksLikeJWT(s string) bool {
+	dots := 0
+	for _, r := range s {
+		if r == '.' {
+			dots++
+		}
+	}
+	return dots == 2
+}
+
+// jwksCache holds the issuer's current signing keys, keyed by kid, and
+// refreshes them on a timer.
+type jwksCache struct {
+	jwksURL string
+
+	mu   sync.RWMutex
+	keys map[string]*rsa.PublicKey
+}
+
+type discoveryDoc struct {
+	JWKSURI string `json:"jwks_uri"`
+}
+
+type jwkSet struct {
+	Keys []jwk `json:"keys"`
+}
+
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+func newJWKSCache(issuer string, refresh time.Duration) (*jwksCache, error) {
+	var doc discoveryDoc
+	if err := getJSON(issuer+discoveryPathSuffix, &doc); err != nil {
+		return nil, fmt.Errorf("oidc: failed to discover issuer %q: %s", issuer, err)
+	}
+	if doc.JWKSURI == "" {
+		return nil, fmt.Errorf("oidc: issuer %q did not advertise a jwks_uri", issuer)
+	}
+
+	c := &jwksCache{jwksURL: doc.JWKSURI}
+	if err := c.refresh(); err != nil {
+		return nil, err
+	}
+
+	if refresh <= 0 {
+		refresh = 10 * time.Minute
+	}
+	go c.refreshLoop(refresh)
+
+	return c, nil
+}
+
+func (c *jwksCache) refreshLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := c.refresh(); err != nil {
+			log.Errorf("oidc: failed to refresh jwks from %s: %s", c.jwksURL, err)
+		}
+	}
+}
+
+func (c *jwksCache) refresh() error {
+	var set jwkSet
+	if err := getJSON(c.jwksURL, &set); err != nil {
+		return err
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		pub, err := k.publicKey()
+		if err != nil {
+			log.Warnf("oidc: skipping key %q: %s", k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+
+	c.mu.Lock()
+	c.keys = keys
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *jwksCache) Get(kid string) (*rsa.PublicKey, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	key, ok := c.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("oidc: unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+func (k jwk) publicKey() (*rsa.PublicKey, error) {
+	nBytes, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, err
+	}
+	eBytes, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, err
+	}
+
+	e := new(big.Int).SetBytes(eBytes)
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(nBytes),
+		E: int(e.Int64()),
+	}, nil
+}
+
+func getJSON(url string, out interface{}) error {
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d fetching %s", resp.StatusCode, url)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// tokenCache caches decoded users by a hash of the token they were decoded
+// from, with separate soft/hard TTLs so Plugin.Auth can keep serving a
+// recently-verified token through a brief JWKS outage.
+type tokenCache struct {
+	mu      sync.Mutex
+	entries map[string]tokenCacheEntry
+}
+
+type tokenCacheEntry struct {
+	user       *auth.User
+	verifiedAt time.Time
+}
+
+func newTokenCache() *tokenCache {
+	return &tokenCache{entries: make(map[string]tokenCacheEntry)}
+}
+
+// Get returns the cached user for key, if any, and whether it's still
+// within its soft TTL.
+func (c *tokenCache) Get(key string) (*auth.User, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	return e.user, time.Since(e.verifiedAt) < tokenSoftTTL
+}
+
+// GetStale returns the cached user for key, if any, as long as it's still
+// within its hard TTL, regardless of whether it's gone stale.
+func (c *tokenCache) GetStale(key string) (*auth.User, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || time.Since(e.verifiedAt) >= tokenHardTTL {
+		return nil, false
+	}
+	return e.user, true
+}
+
+func (c *tokenCache) Set(key string, user *auth.User) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = tokenCacheEntry{user: user, verifiedAt: time.Now()}
+}
+
+func tokenCacheKey(token string) string {
+	// the cache is keyed by a SHA-256 of the token so raw bearer tokens
+	// are never held in memory as map keys.
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,47 @@
+package oidc
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/raintank/tsdb-gw/auth"
+)
+
+func TestLooksLikeJWT(t *testing.T) {
+	Convey("When checking candidate tokens", t, func() {
+		So(looksLikeJWT("header.payload.signature"), ShouldBeTrue)
+		So(looksLikeJWT("plain-api-key"), ShouldBeFalse)
+		So(looksLikeJWT(""), ShouldBeFalse)
+	})
+}
+
+func TestTokenCacheSoftAndHardTTL(t *testing.T) {
+	Convey("Given a token cache with a fresh entry", t, func() {
+		c := newTokenCache()
+		u := &auth.User{OrgId: 2}
+		c.Set("tok", u)
+
+		Convey("Get reports it as fresh", func() {
+			got, fresh := c.Get("tok")
+			So(got, ShouldEqual, u)
+			So(fresh, ShouldBeTrue)
+		})
+
+		Convey("an aged entry is no longer fresh but still available as stale", func() {
+			c.entries["tok"] = tokenCacheEntry{user: u, verifiedAt: c.entries["tok"].verifiedAt.Add(-2 * tokenSoftTTL)}
+			_, fresh := c.Get("tok")
+			So(fresh, ShouldBeFalse)
+
+			stale, ok := c.GetStale("tok")
+			So(ok, ShouldBeTrue)
+			So(stale, ShouldEqual, u)
+		})
+
+		Convey("an entry older than the hard TTL is not returned at all", func() {
+			c.entries["tok"] = tokenCacheEntry{user: u, verifiedAt: c.entries["tok"].verifiedAt.Add(-2 * tokenHardTTL)}
+			_, ok := c.GetStale("tok")
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
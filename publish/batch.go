@@ -0,0 +1,67 @@
+package publish
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	schema "gopkg.in/raintank/schema.v1"
+)
+
+// flushFunc flushes a batch of metrics to the backing system.
+type flushFunc func(batch []*schema.MetricData) error
+
+// batcher accumulates metrics until either maxSize is reached or maxWait
+// has elapsed since the first metric in the batch arrived, whichever comes
+// first, then hands the accumulated batch to flush.
+type batcher struct {
+	maxSize int
+	maxWait time.Duration
+	flush   flushFunc
+
+	mu    sync.Mutex
+	batch []*schema.MetricData
+	timer *time.Timer
+}
+
+func newBatcher(maxSize int, maxWait time.Duration, flush flushFunc) *batcher {
+	return &batcher{maxSize: maxSize, maxWait: maxWait, flush: flush}
+}
+
+// Add appends metrics to the current batch, flushing immediately if that
+// fills it.
+func (b *batcher) Add(metrics []*schema.MetricData) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.batch = append(b.batch, metrics...)
+	if b.timer == nil {
+		b.timer = time.AfterFunc(b.maxWait, b.flushOnTimer)
+	}
+	if len(b.batch) >= b.maxSize {
+		return b.flushLocked()
+	}
+	return nil
+}
+
+func (b *batcher) flushOnTimer() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.batch) == 0 {
+		return
+	}
+	if err := b.flushLocked(); err != nil {
+		log.Errorf("publish: scheduled batch flush failed: %s", err)
+	}
+}
+
+// flushLocked flushes the current batch and resets it. b.mu must be held.
+func (b *batcher) flushLocked() error {
+	batch := b.batch
+	b.batch = nil
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	return b.flush(batch)
+}
@@ -0,0 +1,70 @@
+package publish
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	schema "gopkg.in/raintank/schema.v1"
+)
+
+// recordingFlush collects every batch handed to it by a batcher, so tests
+// can assert on flush count/contents without a real backing publisher.
+type recordingFlush struct {
+	mu      sync.Mutex
+	batches [][]*schema.MetricData
+}
+
+func (r *recordingFlush) flush(batch []*schema.MetricData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.batches = append(r.batches, batch)
+	return nil
+}
+
+func (r *recordingFlush) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.batches)
+}
+
+func TestBatcher(t *testing.T) {
+	Convey("When Add fills the batch to maxSize", t, func() {
+		rec := &recordingFlush{}
+		b := newBatcher(2, time.Hour, rec.flush)
+
+		So(b.Add([]*schema.MetricData{{OrgId: 1}}), ShouldBeNil)
+		So(rec.count(), ShouldEqual, 0)
+		So(b.Add([]*schema.MetricData{{OrgId: 1}}), ShouldBeNil)
+		So(rec.count(), ShouldEqual, 1)
+		So(rec.batches[0], ShouldHaveLength, 2)
+	})
+
+	Convey("When maxWait elapses before the batch fills", t, func() {
+		rec := &recordingFlush{}
+		b := newBatcher(100, 20*time.Millisecond, rec.flush)
+
+		So(b.Add([]*schema.MetricData{{OrgId: 1}}), ShouldBeNil)
+		So(rec.count(), ShouldEqual, 0)
+
+		So(func() int {
+			deadline := time.Now().Add(time.Second)
+			for time.Now().Before(deadline) {
+				if rec.count() > 0 {
+					return rec.count()
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+			return rec.count()
+		}(), ShouldEqual, 1)
+		So(rec.batches[0], ShouldHaveLength, 1)
+	})
+
+	Convey("When flushOnTimer fires with an empty batch", t, func() {
+		rec := &recordingFlush{}
+		b := newBatcher(100, time.Hour, rec.flush)
+		b.flushOnTimer()
+		So(rec.count(), ShouldEqual, 0)
+	})
+}
@@ -0,0 +1,105 @@
+package publish
+
+import (
+	"flag"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	schema "gopkg.in/raintank/schema.v1"
+)
+
+var (
+	kafkaBrokersStr   string
+	kafkaTopic        string
+	kafkaBatchSize    int
+	kafkaBatchTimeout time.Duration
+	kafkaMaxRetries   int
+)
+
+func init() {
+	flag.StringVar(&kafkaBrokersStr, "kafka-publish-brokers", "", "comma separated list of kafka broker addresses. If empty, the kafka publisher is disabled")
+	flag.StringVar(&kafkaTopic, "kafka-publish-topic", "metrics", "kafka topic to publish metrics to")
+	flag.IntVar(&kafkaBatchSize, "kafka-publish-batch-size", 1000, "max number of metrics to batch before flushing to kafka")
+	flag.DurationVar(&kafkaBatchTimeout, "kafka-publish-batch-timeout", time.Second, "max time to wait before flushing a non-full batch to kafka")
+	flag.IntVar(&kafkaMaxRetries, "kafka-publish-max-retries", 5, "max number of retries, with exponential backoff, for a failed kafka flush")
+}
+
+// kafkaPublisher batches metrics and publishes them to a kafka topic,
+// keyed by OrgId so all of an org's metrics land on the same partition and
+// stay ordered relative to each other.
+type kafkaPublisher struct {
+	topic    string
+	producer sarama.SyncProducer
+	batcher  *batcher
+}
+
+// NewKafkaPublisher connects to the kafka brokers configured via
+// -kafka-publish-brokers and returns a Publisher backed by them. It
+// returns (nil, nil) if kafka publishing isn't configured, so callers can
+// treat an unset flag as "don't use kafka" rather than an error.
+func NewKafkaPublisher() (Publisher, error) {
+	if kafkaBrokersStr == "" {
+		return nil, nil
+	}
+
+	cfg := sarama.NewConfig()
+	cfg.Producer.RequiredAcks = sarama.WaitForAll
+	cfg.Producer.Return.Successes = true
+	cfg.Producer.Partitioner = sarama.NewHashPartitioner
+
+	producer, err := sarama.NewSyncProducer(strings.Split(kafkaBrokersStr, ","), cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	k := &kafkaPublisher{
+		topic:    kafkaTopic,
+		producer: producer,
+	}
+	k.batcher = newBatcher(kafkaBatchSize, kafkaBatchTimeout, k.flush)
+	return k, nil
+}
+
+func (k *kafkaPublisher) Publish(metrics []*schema.MetricData) error {
+	return k.batcher.Add(metrics)
+}
+
+func (k *kafkaPublisher) Type() string {
+	return "kafka"
+}
+
+func (k *kafkaPublisher) flush(batch []*schema.MetricData) error {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	start := time.Now()
+	defer func() {
+		flushDuration.WithLabelValues(k.Type()).Observe(time.Since(start).Seconds())
+	}()
+
+	msgs := make([]*sarama.ProducerMessage, len(batch))
+	for i, m := range batch {
+		data, err := m.MarshalMsg(nil)
+		if err != nil {
+			return err
+		}
+		msgs[i] = &sarama.ProducerMessage{
+			Topic: k.topic,
+			Key:   sarama.StringEncoder(strconv.Itoa(m.OrgId)),
+			Value: sarama.ByteEncoder(data),
+		}
+	}
+
+	err := withRetry(k.Type(), kafkaMaxRetries, func() error {
+		return k.producer.SendMessages(msgs)
+	})
+	if err != nil {
+		droppedTotal.WithLabelValues(k.Type()).Add(float64(len(batch)))
+		return err
+	}
+	publishedTotal.WithLabelValues(k.Type()).Add(float64(len(batch)))
+	return nil
+}
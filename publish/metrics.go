@@ -0,0 +1,38 @@
+package publish
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	publishedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "tsdb_gw",
+		Subsystem: "publish",
+		Name:      "published_total",
+		Help:      "total number of metrics successfully published",
+	}, []string{"publisher"})
+
+	droppedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "tsdb_gw",
+		Subsystem: "publish",
+		Name:      "dropped_total",
+		Help:      "total number of metrics dropped after exhausting retries",
+	}, []string{"publisher"})
+
+	retryTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "tsdb_gw",
+		Subsystem: "publish",
+		Name:      "retry_total",
+		Help:      "total number of retried flush attempts",
+	}, []string{"publisher"})
+
+	flushDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "tsdb_gw",
+		Subsystem: "publish",
+		Name:      "flush_duration_seconds",
+		Help:      "time spent flushing a batch to the backing publisher",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"publisher"})
+)
+
+func init() {
+	prometheus.MustRegister(publishedTotal, droppedTotal, retryTotal, flushDuration)
+}
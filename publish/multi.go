@@ -0,0 +1,45 @@
+package publish
+
+import (
+	log "github.com/sirupsen/logrus"
+	schema "gopkg.in/raintank/schema.v1"
+)
+
+// MultiPublisher fans a single Publish() call out to every underlying
+// Publisher, e.g. Kafka and the Persistor service. Each publisher's error,
+// if any, is accounted for independently (its own dropped_total/retry_total
+// series via its Type()); MultiPublisher itself logs each error and returns
+// only the first one, so the caller knows at least one publisher failed.
+type MultiPublisher struct {
+	publishers []Publisher
+}
+
+// NewMultiPublisher returns a Publisher that fans out to all of the given
+// publishers. Any nil entries (e.g. a backend that wasn't configured) are
+// dropped.
+func NewMultiPublisher(publishers ...Publisher) *MultiPublisher {
+	m := &MultiPublisher{}
+	for _, p := range publishers {
+		if p != nil {
+			m.publishers = append(m.publishers, p)
+		}
+	}
+	return m
+}
+
+func (m *MultiPublisher) Publish(metrics []*schema.MetricData) error {
+	var firstErr error
+	for _, p := range m.publishers {
+		if err := p.Publish(metrics); err != nil {
+			log.Errorf("publish: %s publisher failed: %s", p.Type(), err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+func (m *MultiPublisher) Type() string {
+	return "multi"
+}
@@ -0,0 +1,59 @@
+package publish
+
+import (
+	"fmt"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	schema "gopkg.in/raintank/schema.v1"
+)
+
+// fakePublisher records whether Publish was called and optionally fails.
+type fakePublisher struct {
+	name      string
+	err       error
+	published bool
+}
+
+func (f *fakePublisher) Publish(metrics []*schema.MetricData) error {
+	f.published = true
+	return f.err
+}
+
+func (f *fakePublisher) Type() string { return f.name }
+
+func TestMultiPublisher(t *testing.T) {
+	Convey("When one of several publishers fails", t, func() {
+		ok1 := &fakePublisher{name: "ok1"}
+		failing := &fakePublisher{name: "failing", err: fmt.Errorf("boom")}
+		ok2 := &fakePublisher{name: "ok2"}
+
+		m := NewMultiPublisher(ok1, failing, ok2)
+		err := m.Publish([]*schema.MetricData{{OrgId: 1}})
+
+		So(err, ShouldEqual, failing.err)
+		So(ok1.published, ShouldBeTrue)
+		So(failing.published, ShouldBeTrue)
+		So(ok2.published, ShouldBeTrue)
+	})
+
+	Convey("When the first and a later publisher both fail, the first error wins", t, func() {
+		first := &fakePublisher{name: "first", err: fmt.Errorf("first failure")}
+		second := &fakePublisher{name: "second", err: fmt.Errorf("second failure")}
+
+		m := NewMultiPublisher(first, second)
+		err := m.Publish([]*schema.MetricData{{OrgId: 1}})
+
+		So(err, ShouldEqual, first.err)
+	})
+
+	Convey("When nil publishers are passed to NewMultiPublisher", t, func() {
+		ok := &fakePublisher{name: "ok"}
+		m := NewMultiPublisher(nil, ok, nil)
+		So(m.publishers, ShouldHaveLength, 1)
+
+		err := m.Publish([]*schema.MetricData{{OrgId: 1}})
+		So(err, ShouldBeNil)
+		So(ok.published, ShouldBeTrue)
+	})
+}
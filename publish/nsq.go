@@ -0,0 +1,96 @@
+package publish
+
+import (
+	"flag"
+	"time"
+
+	nsq "github.com/nsqio/go-nsq"
+	schema "gopkg.in/raintank/schema.v1"
+)
+
+var (
+	nsqdAddr        string
+	nsqTopic        string
+	nsqBatchSize    int
+	nsqBatchTimeout time.Duration
+	nsqMaxRetries   int
+)
+
+func init() {
+	flag.StringVar(&nsqdAddr, "nsq-publish-nsqd-addr", "", "tcp address of the nsqd to publish to. If empty, the nsq publisher is disabled")
+	flag.StringVar(&nsqTopic, "nsq-publish-topic", "metrics", "nsq topic to publish metrics to")
+	flag.IntVar(&nsqBatchSize, "nsq-publish-batch-size", 1000, "max number of metrics to batch before flushing to nsq")
+	flag.DurationVar(&nsqBatchTimeout, "nsq-publish-batch-timeout", time.Second, "max time to wait before flushing a non-full batch to nsq")
+	flag.IntVar(&nsqMaxRetries, "nsq-publish-max-retries", 5, "max number of retries, with exponential backoff, for a failed nsq flush")
+}
+
+// nsqPublisher batches metrics and publishes them to an nsq topic in a
+// single MPUB call per flush. nsq has no notion of partitions, so unlike
+// kafkaPublisher there's no per-OrgId keying.
+type nsqPublisher struct {
+	topic   string
+	conn    *nsq.Producer
+	batcher *batcher
+}
+
+// NewNSQPublisher connects to the nsqd configured via -nsq-publish-nsqd-addr
+// and returns a Publisher backed by it. It returns (nil, nil) if nsq
+// publishing isn't configured.
+func NewNSQPublisher() (Publisher, error) {
+	if nsqdAddr == "" {
+		return nil, nil
+	}
+
+	conn, err := nsq.NewProducer(nsqdAddr, nsq.NewConfig())
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.Ping(); err != nil {
+		return nil, err
+	}
+
+	n := &nsqPublisher{
+		topic: nsqTopic,
+		conn:  conn,
+	}
+	n.batcher = newBatcher(nsqBatchSize, nsqBatchTimeout, n.flush)
+	return n, nil
+}
+
+func (n *nsqPublisher) Publish(metrics []*schema.MetricData) error {
+	return n.batcher.Add(metrics)
+}
+
+func (n *nsqPublisher) Type() string {
+	return "nsq"
+}
+
+func (n *nsqPublisher) flush(batch []*schema.MetricData) error {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	start := time.Now()
+	defer func() {
+		flushDuration.WithLabelValues(n.Type()).Observe(time.Since(start).Seconds())
+	}()
+
+	bodies := make([][]byte, len(batch))
+	for i, m := range batch {
+		data, err := m.MarshalMsg(nil)
+		if err != nil {
+			return err
+		}
+		bodies[i] = data
+	}
+
+	err := withRetry(n.Type(), nsqMaxRetries, func() error {
+		return n.conn.MultiPublish(n.topic, bodies)
+	})
+	if err != nil {
+		droppedTotal.WithLabelValues(n.Type()).Add(float64(len(batch)))
+		return err
+	}
+	publishedTotal.WithLabelValues(n.Type()).Add(float64(len(batch)))
+	return nil
+}
@@ -13,6 +13,7 @@ type Publisher interface {
 
 var (
 	publisher Publisher
+	persister Publisher
 
 	// Persister allows pushing metrics to the Persistor Service
 	Persistor *metrics_client.Client
@@ -27,6 +28,19 @@ func Init(p Publisher) {
 	log.Infof("using %s publisher", publisher.Type())
 }
 
+// InitPersister sets the Publisher used by Persist. It's configured
+// separately from Init's publisher because persisted metrics may take a
+// different path than ingested ones, e.g. a MultiPublisher fanning out to
+// both Kafka and the Persistor Service.
+func InitPersister(p Publisher) {
+	if p == nil {
+		persister = &nullPublisher{}
+	} else {
+		persister = p
+	}
+	log.Infof("using %s persister", persister.Type())
+}
+
 func Publish(metrics []*schema.MetricData) error {
 	return publisher.Publish(metrics)
 }
@@ -43,6 +57,12 @@ func (*nullPublisher) Type() string {
 	return "nullPublisher"
 }
 
+// Persist publishes metrics via whatever InitPersister configured. Callers
+// that only called Init, and never called InitPersister, keep getting the
+// old behavior of Persist aliasing Publish.
 func Persist(metrics []*schema.MetricData) error {
-	return publisher.Publish(metrics)
+	if persister == nil {
+		return publisher.Publish(metrics)
+	}
+	return persister.Publish(metrics)
 }
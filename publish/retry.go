@@ -0,0 +1,35 @@
+package publish
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const maxRetryBackoff = 30 * time.Second
+
+// withRetry calls fn, retrying with exponential backoff up to maxRetries
+// times if it returns an error. Every retry increments retryTotal for
+// publisher; the final error, if any, is returned to the caller to account
+// for as dropped.
+func withRetry(publisher string, maxRetries int, fn func() error) error {
+	backoff := 100 * time.Millisecond
+
+	var err error
+	for attempt := 0; attempt <= maxRetries; attempt++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		if attempt == maxRetries {
+			break
+		}
+		retryTotal.WithLabelValues(publisher).Inc()
+		log.Warnf("publish(%s): flush failed (attempt %d/%d): %s", publisher, attempt+1, maxRetries+1, err)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+	return err
+}
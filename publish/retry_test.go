@@ -0,0 +1,49 @@
+package publish
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWithRetry(t *testing.T) {
+	Convey("When fn succeeds on the first attempt", t, func() {
+		calls := 0
+		err := withRetry("test", 5, func() error {
+			calls++
+			return nil
+		})
+		So(err, ShouldBeNil)
+		So(calls, ShouldEqual, 1)
+	})
+
+	Convey("When fn succeeds after a few failures", t, func() {
+		calls := 0
+		err := withRetry("test", 5, func() error {
+			calls++
+			if calls < 3 {
+				return fmt.Errorf("transient failure")
+			}
+			return nil
+		})
+		So(err, ShouldBeNil)
+		So(calls, ShouldEqual, 3)
+	})
+
+	Convey("When fn always fails, withRetry gives up after maxRetries", t, func() {
+		calls := 0
+		start := time.Now()
+		err := withRetry("test", 2, func() error {
+			calls++
+			return fmt.Errorf("permanent failure")
+		})
+		So(err, ShouldNotBeNil)
+		// maxRetries=2 means 3 total attempts: the initial try plus 2 retries.
+		So(calls, ShouldEqual, 3)
+		// backoff starts at 100ms and doubles between the 2 retries
+		// (100ms + 200ms), so this should take at least 300ms.
+		So(time.Since(start), ShouldBeGreaterThanOrEqualTo, 300*time.Millisecond)
+	})
+}